@@ -0,0 +1,226 @@
+// Package buffer provides a durable on-disk write-ahead buffer for DNS
+// logs that failed to insert into the primary sink, so a database outage
+// doesn't drop stream events whose cursor has already advanced.
+package buffer
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/enxoco/nextdns-sync/storage"
+)
+
+// Buffer durably queues DNSLog entries on disk (LevelDB + CBOR) when the
+// primary sink rejects an insert, and drains them back into the sink in
+// the background once it recovers.
+type Buffer struct {
+	db       *leveldb.DB
+	sink     storage.Sink
+	maxBytes int64
+
+	retryBase time.Duration
+	retryMax  time.Duration
+
+	// sizeMu guards size, a running total of the buffer's on-disk bytes
+	// maintained incrementally by Put/evictOverflow/drainOnce, so checking
+	// it never costs a full scan of the store.
+	sizeMu sync.Mutex
+	size   int64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens (and creates, if missing) a write-ahead buffer at dir,
+// draining into sink as it recovers. maxBytes <= 0 means unbounded.
+func Open(dir string, sink storage.Sink, maxBytes int64) (*Buffer, error) {
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open buffer at %s: %w", dir, err)
+	}
+
+	b := &Buffer{
+		db:        db,
+		sink:      sink,
+		maxBytes:  maxBytes,
+		retryBase: 1 * time.Second,
+		retryMax:  1 * time.Minute,
+		done:      make(chan struct{}),
+	}
+
+	size, err := b.scanSize()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("scan buffer at %s: %w", dir, err)
+	}
+	b.size = size
+
+	b.wg.Add(1)
+	go b.drain()
+
+	return b, nil
+}
+
+// key returns the LevelDB key for log, ordered so an iterator walks
+// entries oldest-first: a zero-padded timestamp_ns, then the log ID to
+// break ties.
+func key(l *storage.DNSLog) []byte {
+	return []byte(fmt.Sprintf("%020d|%s", l.Timestamp.UnixNano(), l.ID))
+}
+
+// Put durably appends log to the buffer, generating its ID if unset, then
+// evicts the oldest entries if the buffer has grown past maxBytes.
+func (b *Buffer) Put(l *storage.DNSLog) error {
+	if l.ID == "" {
+		l.GenerateID()
+	}
+
+	data, err := cbor.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("encode buffered log: %w", err)
+	}
+
+	k := key(l)
+	if err := b.db.Put(k, data, nil); err != nil {
+		return fmt.Errorf("write buffered log: %w", err)
+	}
+	b.grow(int64(len(k) + len(data)))
+
+	if b.maxBytes > 0 {
+		b.evictOverflow()
+	}
+
+	return nil
+}
+
+// evictOverflow drops the oldest entries until the buffer's tracked size
+// is back under maxBytes. It trusts the running size counter rather than
+// rescanning the store, so eviction stays cheap no matter how long an
+// outage has let the buffer grow.
+func (b *Buffer) evictOverflow() {
+	b.sizeMu.Lock()
+	size := b.size
+	b.sizeMu.Unlock()
+
+	if size <= b.maxBytes {
+		return
+	}
+
+	iter := b.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for size > b.maxBytes && iter.Next() {
+		entrySize := int64(len(iter.Key()) + len(iter.Value()))
+		if err := b.db.Delete(iter.Key(), nil); err != nil {
+			log.Printf("Warning: failed to evict buffered log: %v", err)
+			break
+		}
+		size -= entrySize
+		b.shrink(entrySize)
+	}
+}
+
+// scanSize walks the whole store to compute its on-disk size. It's only
+// called once, at Open, to seed the running size counter; after that,
+// Put/evictOverflow/drainOnce keep it up to date incrementally.
+func (b *Buffer) scanSize() (int64, error) {
+	iter := b.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	var total int64
+	for iter.Next() {
+		total += int64(len(iter.Key()) + len(iter.Value()))
+	}
+	return total, iter.Error()
+}
+
+// grow and shrink adjust the running size counter as entries are written
+// to and deleted from the store.
+func (b *Buffer) grow(n int64) {
+	b.sizeMu.Lock()
+	b.size += n
+	b.sizeMu.Unlock()
+}
+
+func (b *Buffer) shrink(n int64) {
+	b.sizeMu.Lock()
+	b.size -= n
+	b.sizeMu.Unlock()
+}
+
+// drain retries buffered entries, oldest first, on a timer, backing off
+// exponentially between sweeps that fail to fully drain.
+func (b *Buffer) drain() {
+	defer b.wg.Done()
+
+	delay := b.retryBase
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-time.After(delay):
+		}
+
+		drained, err := b.drainOnce()
+		if err != nil {
+			log.Printf("Buffer drain sweep failed: %v", err)
+			delay *= 2
+			if delay > b.retryMax {
+				delay = b.retryMax
+			}
+			continue
+		}
+
+		if drained > 0 {
+			log.Printf("Drained %d buffered logs back into the sink", drained)
+		}
+		delay = b.retryBase
+	}
+}
+
+// drainOnce walks the buffer oldest-first, inserting each entry into the
+// sink and deleting it on success. It stops at the first insert failure
+// so a still-down sink isn't hammered with the rest of the backlog.
+func (b *Buffer) drainOnce() (int, error) {
+	iter := b.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	drained := 0
+	for iter.Next() {
+		entrySize := int64(len(iter.Key()) + len(iter.Value()))
+
+		var l storage.DNSLog
+		if err := cbor.Unmarshal(iter.Value(), &l); err != nil {
+			log.Printf("Warning: dropping corrupt buffered log: %v", err)
+			if err := b.db.Delete(iter.Key(), nil); err != nil {
+				return drained, err
+			}
+			b.shrink(entrySize)
+			continue
+		}
+
+		if _, err := b.sink.Insert(&l); err != nil {
+			return drained, err
+		}
+
+		if err := b.db.Delete(iter.Key(), nil); err != nil {
+			return drained, err
+		}
+		b.shrink(entrySize)
+		drained++
+	}
+
+	return drained, iter.Error()
+}
+
+// Close stops the drain loop and closes the underlying LevelDB handle.
+func (b *Buffer) Close() error {
+	close(b.done)
+	b.wg.Wait()
+	return b.db.Close()
+}
@@ -0,0 +1,282 @@
+// Package config resolves nextdns-sync's settings from CLI flags,
+// environment variables, and an optional JSON/YAML config file, with
+// precedence CLI flag > env var > config file > built-in default. CLI
+// parsing and the flag/env layers are handled by kong; the config file is
+// plugged in as a kong resolver, so a single struct tag on each field is
+// enough to wire up all three sources.
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v3"
+)
+
+// ProfileConfig is a single NextDNS profile to sync, as declared in the
+// config file's nextdns.profiles list.
+type ProfileConfig struct {
+	ID     string `yaml:"id" json:"id"`
+	APIKey string `yaml:"apikey" json:"apikey"`
+}
+
+// CLI is the full flag/env surface for nextdns-sync. Fields are grouped by
+// the config file section they mirror, in this order: nextdns, storage,
+// sinks.loki, enrichment.geoip, metrics, retention, buffer.
+type CLI struct {
+	Config string `name:"config" help:"Path to a JSON or YAML config file."`
+
+	Profile       string   `help:"NextDNS Profile ID (shorthand for a single profile)." env:"NEXTDNS_PROFILE_ID"`
+	APIKey        string   `name:"apikey" help:"NextDNS API Key (shorthand for a single profile)." env:"NEXTDNS_API_KEY"`
+	ExtraProfiles []string `name:"profiles" help:"Additional profile as id:apikey; repeatable. Sync several profiles concurrently by combining this with -profile, or by listing nextdns.profiles in the config file." env:"NEXTDNS_PROFILES"`
+
+	Backend string `default:"postgres" help:"Storage backend: postgres, sqlite, clickhouse, or none." env:"NEXTDNS_BACKEND"`
+	DB      string `help:"Storage connection string (DSN, or file path for sqlite)." env:"DATABASE_URL"`
+
+	LokiURL       string        `name:"loki-url" help:"Grafana Loki base URL, e.g. http://localhost:3100 (enables the Loki sink)." env:"NEXTDNS_LOKI_URL"`
+	LokiTenant    string        `name:"loki-tenant" help:"Loki tenant ID, sent as X-Scope-OrgID." env:"NEXTDNS_LOKI_TENANT"`
+	LokiUser      string        `name:"loki-user" help:"Loki basic auth username." env:"NEXTDNS_LOKI_USER"`
+	LokiPass      string        `name:"loki-pass" help:"Loki basic auth password." env:"NEXTDNS_LOKI_PASS"`
+	LokiBatchSize int           `name:"loki-batch-size" default:"500" help:"Max number of log entries per Loki push."`
+	LokiMaxDelay  time.Duration `name:"loki-max-delay" default:"5s" help:"Max time to buffer log entries before pushing to Loki."`
+
+	GeoIPCity string `name:"geoip-city" help:"Path to a MaxMind GeoLite2-City mmdb (enables country/city/lat-lon enrichment)." env:"NEXTDNS_GEOIP_CITY"`
+	GeoIPASN  string `name:"geoip-asn" help:"Path to a MaxMind GeoLite2-ASN mmdb (enables ASN enrichment)." env:"NEXTDNS_GEOIP_ASN"`
+
+	MetricsAddr string `name:"metrics-addr" default:":9090" help:"Listen address for the /metrics, /healthz, and /readyz HTTP server." env:"NEXTDNS_METRICS_ADDR"`
+	AdminToken  string `name:"admin-token" help:"Token required (as X-Admin-Token) for /debug endpoints; leave unset to disable them." env:"NEXTDNS_ADMIN_TOKEN"`
+
+	Retention         string        `help:"Delete logs older than this; e.g. \"90d\" or \"allowed=30d,blocked=365d,default=90d\". Leave unset to disable." env:"NEXTDNS_RETENTION"`
+	RetentionInterval time.Duration `name:"retention-interval" default:"1h" help:"How often to sweep for expired logs."`
+
+	BufferDir     string `name:"buffer-dir" help:"Directory for a durable write-ahead buffer of logs that failed to insert; leave unset to disable." env:"NEXTDNS_BUFFER_DIR"`
+	BufferMaxSize int64  `name:"buffer-max-size" help:"Max size in bytes of the write-ahead buffer before oldest entries are evicted; 0 means unbounded."`
+
+	fileProfiles []ProfileConfig
+}
+
+// Load parses os.Args-style CLI args into a CLI, applying env vars and,
+// if -config (or NEXTDNS_CONFIG) names a file, its values as the bottom
+// layer of precedence: CLI flag > env var > config file > default.
+func Load(args []string) (*CLI, error) {
+	var cli CLI
+
+	configPath := extractConfigFlag(args)
+
+	var opts []kong.Option
+	if configPath != "" {
+		resolver, profiles, err := loadConfigFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("load config file %s: %w", configPath, err)
+		}
+		opts = append(opts, kong.Resolvers(resolver))
+		cli.fileProfiles = profiles
+	}
+
+	parser, err := kong.New(&cli, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parser.Parse(args); err != nil {
+		return nil, err
+	}
+
+	return &cli, nil
+}
+
+// extractConfigFlag finds -config/--config in args (or NEXTDNS_CONFIG in
+// the environment) before the real kong parse runs, since the config
+// file's path has to be known in order to register it as a resolver.
+func extractConfigFlag(args []string) string {
+	for i, a := range args {
+		switch {
+		case a == "-config" || a == "--config":
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+		case strings.HasPrefix(a, "-config="):
+			return strings.TrimPrefix(a, "-config=")
+		case strings.HasPrefix(a, "--config="):
+			return strings.TrimPrefix(a, "--config=")
+		}
+	}
+	return os.Getenv("NEXTDNS_CONFIG")
+}
+
+// configFile mirrors the config file's section layout. loadConfigFile maps
+// each flag name to its field here, e.g. the flag "loki-url" to
+// sinks.loki.url.
+type configFile struct {
+	NextDNS struct {
+		Profile  string          `yaml:"profile" json:"profile"`
+		APIKey   string          `yaml:"apikey" json:"apikey"`
+		Profiles []ProfileConfig `yaml:"profiles" json:"profiles"`
+	} `yaml:"nextdns" json:"nextdns"`
+	Storage struct {
+		Backend string `yaml:"backend" json:"backend"`
+		DSN     string `yaml:"dsn" json:"dsn"`
+	} `yaml:"storage" json:"storage"`
+	Sinks struct {
+		Loki struct {
+			URL       string `yaml:"url" json:"url"`
+			Tenant    string `yaml:"tenant" json:"tenant"`
+			User      string `yaml:"user" json:"user"`
+			Pass      string `yaml:"pass" json:"pass"`
+			BatchSize int    `yaml:"batch_size" json:"batch_size"`
+			MaxDelay  string `yaml:"max_delay" json:"max_delay"`
+		} `yaml:"loki" json:"loki"`
+	} `yaml:"sinks" json:"sinks"`
+	Enrichment struct {
+		GeoIP struct {
+			City string `yaml:"city" json:"city"`
+			ASN  string `yaml:"asn" json:"asn"`
+		} `yaml:"geoip" json:"geoip"`
+	} `yaml:"enrichment" json:"enrichment"`
+	Retention struct {
+		Rules    string `yaml:"rules" json:"rules"`
+		Interval string `yaml:"interval" json:"interval"`
+	} `yaml:"retention" json:"retention"`
+	Metrics struct {
+		Addr       string `yaml:"addr" json:"addr"`
+		AdminToken string `yaml:"admin_token" json:"admin_token"`
+	} `yaml:"metrics" json:"metrics"`
+	Buffer struct {
+		Dir     string `yaml:"dir" json:"dir"`
+		MaxSize int64  `yaml:"max_size" json:"max_size"`
+	} `yaml:"buffer" json:"buffer"`
+}
+
+// flagEnvVars maps each flag name with an `env` tag on CLI to that env
+// var's name, so the config-file resolver can tell when a flag was
+// already satisfied by the environment. kong's Resolve hook only sees
+// whether a flag was set on the command line, not whether ctx.Reset()
+// already populated it from the environment -- so without this check, a
+// config file value would silently clobber an env var, inverting the
+// documented CLI > env > config file > default precedence.
+var flagEnvVars = map[string]string{
+	"profile":      "NEXTDNS_PROFILE_ID",
+	"apikey":       "NEXTDNS_API_KEY",
+	"profiles":     "NEXTDNS_PROFILES",
+	"backend":      "NEXTDNS_BACKEND",
+	"db":           "DATABASE_URL",
+	"loki-url":     "NEXTDNS_LOKI_URL",
+	"loki-tenant":  "NEXTDNS_LOKI_TENANT",
+	"loki-user":    "NEXTDNS_LOKI_USER",
+	"loki-pass":    "NEXTDNS_LOKI_PASS",
+	"geoip-city":   "NEXTDNS_GEOIP_CITY",
+	"geoip-asn":    "NEXTDNS_GEOIP_ASN",
+	"retention":    "NEXTDNS_RETENTION",
+	"metrics-addr": "NEXTDNS_METRICS_ADDR",
+	"admin-token":  "NEXTDNS_ADMIN_TOKEN",
+	"buffer-dir":   "NEXTDNS_BUFFER_DIR",
+}
+
+// loadConfigFile reads path (YAML, or JSON, which parses fine as a YAML
+// subset) and returns a kong.Resolver that supplies each flag's value
+// from the matching section, plus any additional profiles declared under
+// nextdns.profiles.
+func loadConfigFile(path string) (kong.Resolver, []ProfileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var f configFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, nil, err
+	}
+
+	values := map[string]string{
+		"profile":            f.NextDNS.Profile,
+		"apikey":             f.NextDNS.APIKey,
+		"backend":            f.Storage.Backend,
+		"db":                 f.Storage.DSN,
+		"loki-url":           f.Sinks.Loki.URL,
+		"loki-tenant":        f.Sinks.Loki.Tenant,
+		"loki-user":          f.Sinks.Loki.User,
+		"loki-pass":          f.Sinks.Loki.Pass,
+		"geoip-city":         f.Enrichment.GeoIP.City,
+		"geoip-asn":          f.Enrichment.GeoIP.ASN,
+		"retention":          f.Retention.Rules,
+		"retention-interval": f.Retention.Interval,
+		"metrics-addr":       f.Metrics.Addr,
+		"admin-token":        f.Metrics.AdminToken,
+		"buffer-dir":         f.Buffer.Dir,
+	}
+	if f.Sinks.Loki.BatchSize != 0 {
+		values["loki-batch-size"] = fmt.Sprintf("%d", f.Sinks.Loki.BatchSize)
+	}
+	if f.Sinks.Loki.MaxDelay != "" {
+		values["loki-max-delay"] = f.Sinks.Loki.MaxDelay
+	}
+	if f.Buffer.MaxSize != 0 {
+		values["buffer-max-size"] = fmt.Sprintf("%d", f.Buffer.MaxSize)
+	}
+
+	resolver := kong.ResolverFunc(func(_ *kong.Context, _ *kong.Path, flag *kong.Flag) (interface{}, error) {
+		if envVar, ok := flagEnvVars[flag.Name]; ok {
+			if _, set := os.LookupEnv(envVar); set {
+				return nil, nil
+			}
+		}
+		v, ok := values[flag.Name]
+		if !ok || v == "" {
+			return nil, nil
+		}
+		return v, nil
+	})
+
+	return resolver, f.NextDNS.Profiles, nil
+}
+
+// Profiles returns the full set of NextDNS profiles to sync: the
+// Profile/APIKey shorthand (if set), any -profiles id:apikey entries, and
+// any nextdns.profiles declared in the config file. Duplicate IDs keep
+// the first occurrence, in that precedence order.
+func (c *CLI) Profiles() ([]ProfileConfig, error) {
+	var profiles []ProfileConfig
+	seen := map[string]bool{}
+
+	add := func(id, apikey string) error {
+		if id == "" || apikey == "" {
+			return fmt.Errorf("profile %q is missing an id or apikey", id)
+		}
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+		profiles = append(profiles, ProfileConfig{ID: id, APIKey: apikey})
+		return nil
+	}
+
+	if c.Profile != "" {
+		if err := add(c.Profile, c.APIKey); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range c.ExtraProfiles {
+		id, apikey, ok := strings.Cut(p, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid -profiles entry %q, want id:apikey", p)
+		}
+		if err := add(id, apikey); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range c.fileProfiles {
+		if err := add(p.ID, p.APIKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("no NextDNS profile configured; set -profile/-apikey, -profiles, or nextdns.profiles in the config file")
+	}
+
+	return profiles, nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigFileAppliesOverDefault(t *testing.T) {
+	path := writeConfigFile(t, "storage:\n  backend: sqlite\n")
+
+	cli, err := Load([]string{
+		"--config", path,
+		"--profile", "p1", "--apikey", "key1",
+	})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cli.Backend != "sqlite" {
+		t.Errorf("Backend = %q, want %q (config file should beat the built-in default)", cli.Backend, "sqlite")
+	}
+}
+
+func TestLoadEnvVarBeatsConfigFile(t *testing.T) {
+	path := writeConfigFile(t, "storage:\n  dsn: file-dsn\n")
+	t.Setenv("DATABASE_URL", "env-dsn")
+
+	cli, err := Load([]string{
+		"--config", path,
+		"--profile", "p1", "--apikey", "key1",
+	})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cli.DB != "env-dsn" {
+		t.Errorf("DB = %q, want %q (an env var must not be overridden by the config file)", cli.DB, "env-dsn")
+	}
+}
+
+func TestLoadCLIFlagBeatsEnvVar(t *testing.T) {
+	t.Setenv("DATABASE_URL", "env-dsn")
+
+	cli, err := Load([]string{
+		"--profile", "p1", "--apikey", "key1",
+		"--db", "flag-dsn",
+	})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cli.DB != "flag-dsn" {
+		t.Errorf("DB = %q, want %q (a CLI flag must win over an env var)", cli.DB, "flag-dsn")
+	}
+}
+
+func TestLoadCLIFlagBeatsConfigFile(t *testing.T) {
+	path := writeConfigFile(t, "storage:\n  backend: sqlite\n")
+
+	cli, err := Load([]string{
+		"--config", path,
+		"--profile", "p1", "--apikey", "key1",
+		"--backend", "clickhouse",
+	})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cli.Backend != "clickhouse" {
+		t.Errorf("Backend = %q, want %q (a CLI flag must win over the config file)", cli.Backend, "clickhouse")
+	}
+}
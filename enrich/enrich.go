@@ -0,0 +1,127 @@
+// Package enrich resolves a client IP against local MaxMind GeoLite2 City
+// and ASN databases, caching results so repeat clients aren't re-resolved.
+package enrich
+
+import (
+	"log"
+	"net"
+	"sync"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// cacheSize bounds the number of distinct IPs kept in the in-process LRU.
+const cacheSize = 10000
+
+// Info holds the location and network fields resolved for a client IP.
+type Info struct {
+	Country   string
+	City      string
+	Latitude  float64
+	Longitude float64
+	ASN       uint
+	ASNOrg    string
+}
+
+// Enricher looks up GeoIP and ASN info for client IPs. A nil *Enricher is
+// valid and Lookup on it returns a zero Info, so enrichment can be disabled
+// by simply not constructing one.
+type Enricher struct {
+	city *geoip2.Reader
+	asn  *geoip2.Reader
+
+	mu    sync.Mutex
+	cache *lru
+}
+
+// New opens the configured GeoLite2 databases. Either path may be empty to
+// skip that database; if both are empty, New returns a nil Enricher so
+// callers can treat enrichment as disabled throughout. A path that's set
+// but can't be opened is logged and skipped rather than treated as fatal --
+// enrichment is optional and the rest of the pipeline must keep working
+// without it.
+func New(cityPath, asnPath string) (*Enricher, error) {
+	if cityPath == "" && asnPath == "" {
+		return nil, nil
+	}
+
+	e := &Enricher{cache: newLRU(cacheSize)}
+
+	if cityPath != "" {
+		r, err := geoip2.Open(cityPath)
+		if err != nil {
+			log.Printf("Warning: could not open GeoIP city database %s: %v", cityPath, err)
+		} else {
+			e.city = r
+		}
+	}
+	if asnPath != "" {
+		r, err := geoip2.Open(asnPath)
+		if err != nil {
+			log.Printf("Warning: could not open GeoIP ASN database %s: %v", asnPath, err)
+		} else {
+			e.asn = r
+		}
+	}
+
+	return e, nil
+}
+
+// Lookup resolves ipStr against the configured databases, returning a zero
+// Info if enrichment is disabled, the IP doesn't parse, or no record is
+// found. Results are cached so repeated clients aren't re-resolved.
+func (e *Enricher) Lookup(ipStr string) Info {
+	if e == nil {
+		return Info{}
+	}
+
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return Info{}
+	}
+
+	e.mu.Lock()
+	if info, ok := e.cache.get(ipStr); ok {
+		e.mu.Unlock()
+		return info
+	}
+	e.mu.Unlock()
+
+	var info Info
+
+	if e.city != nil {
+		if rec, err := e.city.City(ip); err == nil {
+			info.Country = rec.Country.IsoCode
+			info.City = rec.City.Names["en"]
+			info.Latitude = rec.Location.Latitude
+			info.Longitude = rec.Location.Longitude
+		}
+	}
+	if e.asn != nil {
+		if rec, err := e.asn.ASN(ip); err == nil {
+			info.ASN = rec.AutonomousSystemNumber
+			info.ASNOrg = rec.AutonomousSystemOrganization
+		}
+	}
+
+	e.mu.Lock()
+	e.cache.put(ipStr, info)
+	e.mu.Unlock()
+
+	return info
+}
+
+// Close releases the underlying mmdb files. It is safe to call on a nil
+// *Enricher.
+func (e *Enricher) Close() error {
+	if e == nil {
+		return nil
+	}
+	if e.city != nil {
+		e.city.Close()
+	}
+	if e.asn != nil {
+		e.asn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+// Package loki forwards DNS logs to a Grafana Loki instance via its HTTP
+// push API, batching entries and retrying transient failures.
+package loki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/enxoco/nextdns-sync/storage"
+)
+
+// Config holds the settings for a Loki push client.
+type Config struct {
+	URL           string // base URL, e.g. http://localhost:3100
+	TenantID      string
+	BasicAuthUser string
+	BasicAuthPass string
+	Profile       string // NextDNS profile ID, applied as a label on every entry
+	BatchSize     int
+	MaxDelay      time.Duration
+}
+
+type entry struct {
+	labels map[string]string
+	tsNano int64
+	line   string
+}
+
+// Client batches DNSLog entries and pushes them to Loki in the background.
+// Push never blocks on network I/O; Close flushes and stops the batcher.
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	pending []entry
+
+	flushNow chan struct{}
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewClient creates a Loki client and starts its background batching loop.
+func NewClient(cfg Config) *Client {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		flushNow:   make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	c.wg.Add(1)
+	go c.run()
+	return c
+}
+
+// Push enqueues a DNS log entry to be forwarded to Loki.
+func (c *Client) Push(l *storage.DNSLog) error {
+	line, err := json.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("marshal log for loki: %w", err)
+	}
+
+	e := entry{
+		labels: map[string]string{
+			"profile":  c.cfg.Profile,
+			"device":   l.DeviceName(),
+			"status":   l.Status,
+			"blocked":  strconv.FormatBool(l.Blocked),
+			"protocol": l.Protocol,
+		},
+		tsNano: l.Timestamp.UnixNano(),
+		line:   string(line),
+	}
+
+	c.mu.Lock()
+	c.pending = append(c.pending, e)
+	full := len(c.pending) >= c.cfg.BatchSize
+	c.mu.Unlock()
+
+	if full {
+		select {
+		case c.flushNow <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// closeGracePeriod bounds how long Close waits for the final flush's push
+// to finish on its own before canceling it outright, so a Loki outage at
+// shutdown time can't hang the process forever.
+const closeGracePeriod = 10 * time.Second
+
+// Close flushes any buffered entries and stops the batching loop. If the
+// final push is still retrying after closeGracePeriod, it's canceled so
+// Close always returns.
+func (c *Client) Close() error {
+	close(c.done)
+
+	stopped := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(closeGracePeriod):
+		c.cancel()
+		<-stopped
+	}
+	return nil
+}
+
+func (c *Client) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.cfg.MaxDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-c.flushNow:
+			c.flush()
+		case <-c.done:
+			c.flush()
+			return
+		}
+	}
+}
+
+func (c *Client) flush() {
+	c.mu.Lock()
+	if len(c.pending) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	batch := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	if err := c.push(c.ctx, batch); err != nil {
+		log.Printf("Warning: failed to push %d logs to Loki: %v", len(batch), err)
+	}
+}
+
+// pushStream is a single entry in Loki's push request body.
+type pushStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// maxPushRetries bounds how many attempts push makes at a request that
+// Loki answers with 429 or a 5xx status before giving up and dropping the
+// batch, so a persistently unhealthy Loki can't retry a single push
+// forever. A transport error is returned at once, without a retry.
+const maxPushRetries = 5
+
+func (c *Client) push(ctx context.Context, entries []entry) error {
+	streams := map[string]*pushStream{}
+	for _, e := range entries {
+		key := streamKey(e.labels)
+		s, ok := streams[key]
+		if !ok {
+			s = &pushStream{Stream: e.labels}
+			streams[key] = s
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(e.tsNano, 10), e.line})
+	}
+
+	body := struct {
+		Streams []*pushStream `json:"streams"`
+	}{}
+	for _, s := range streams {
+		body.Streams = append(body.Streams, s)
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	retryDelay := 1 * time.Second
+	maxRetryDelay := 30 * time.Second
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(c.cfg.URL, "/")+"/loki/api/v1/push", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if c.cfg.TenantID != "" {
+			req.Header.Set("X-Scope-OrgID", c.cfg.TenantID)
+		}
+		if c.cfg.BasicAuthUser != "" {
+			req.SetBasicAuth(c.cfg.BasicAuthUser, c.cfg.BasicAuthPass)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		var wait time.Duration
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests:
+			wait = retryAfter(resp.Header.Get("Retry-After"), retryDelay)
+			log.Printf("Loki push rate-limited, retrying in %v", wait)
+		case resp.StatusCode >= 500:
+			log.Printf("Loki push returned %d, retrying in %v", resp.StatusCode, retryDelay)
+			wait = retryDelay
+			retryDelay *= 2
+			if retryDelay > maxRetryDelay {
+				retryDelay = maxRetryDelay
+			}
+		default:
+			return fmt.Errorf("loki push returned status %d", resp.StatusCode)
+		}
+
+		if attempt+1 >= maxPushRetries {
+			return fmt.Errorf("loki push still failing after %d attempts, dropping batch", attempt+1)
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// streamKey builds a stable, order-independent key for a label set so
+// entries sharing the same labels are batched into the same stream.
+func streamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(';')
+	}
+	return b.String()
+}
+
+// retryAfter parses a Retry-After header (seconds) and falls back to def.
+func retryAfter(header string, def time.Duration) time.Duration {
+	if header == "" {
+		return def
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	return def
+}
@@ -3,138 +3,142 @@ package main
 import (
 	"bufio"
 	"context"
-	"database/sql"
 	"encoding/json"
-	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/enxoco/nextdns-sync/buffer"
+	"github.com/enxoco/nextdns-sync/config"
+	"github.com/enxoco/nextdns-sync/enrich"
+	"github.com/enxoco/nextdns-sync/loki"
+	"github.com/enxoco/nextdns-sync/metrics"
+	"github.com/enxoco/nextdns-sync/retention"
+	"github.com/enxoco/nextdns-sync/storage"
 )
 
-type Device struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+// Config is a single NextDNS profile's sync target; the shared sink,
+// enricher, metrics, and buffer, and the profile's own Loki client, are
+// passed alongside it.
+type Config struct {
+	ProfileID string
+	APIKey    string
 }
 
-type DNSLog struct {
-	ID        string          `json:"-"` // Generated, not from API
-	Timestamp time.Time       `json:"timestamp"`
-	Domain    string          `json:"domain"` // Changed from "name"
-	Type      string          `json:"type"`
-	Status    string          `json:"status"`
-	Blocked   bool            `json:"blocked"`
-	ClientIP  string          `json:"clientIp"`
-	Protocol  string          `json:"protocol"`
-	Device    json.RawMessage `json:"device"`
-	Root      string          `json:"root"`
-	Tracker   string          `json:"tracker"`
-	Encrypted bool            `json:"encrypted"`
-}
+func main() {
+	cli, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
 
-// GenerateID creates a unique ID from timestamp, domain, and client IP
-func (log *DNSLog) GenerateID() {
-	// Create a deterministic ID from key fields
-	log.ID = fmt.Sprintf("%d-%s-%s", log.Timestamp.UnixNano(), log.Domain, log.ClientIP)
-}
+	profiles, err := cli.Profiles()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-func (log *DNSLog) DeviceName() string {
-	if len(log.Device) == 0 {
-		return ""
+	backend := cli.Backend
+	if cli.DB == "" && cli.LokiURL == "" {
+		log.Fatal("No sink configured. Set -db (with -backend none to skip a database) and/or -loki-url.")
 	}
-	// Try to parse as object
-	var device Device
-	if err := json.Unmarshal(log.Device, &device); err == nil {
-		return device.Name
+	if cli.DB == "" {
+		backend = "none"
 	}
-	// Try as string
-	var deviceStr string
-	if err := json.Unmarshal(log.Device, &deviceStr); err == nil {
-		return deviceStr
+
+	sink, err := storage.Open(backend, cli.DB)
+	if err != nil {
+		log.Fatalf("Failed to open %s storage backend: %v", backend, err)
 	}
-	return ""
-}
+	defer sink.Close()
 
-type Config struct {
-	ProfileID string
-	APIKey    string
-	DBURL     string
-}
+	if err := sink.Init(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
 
-func main() {
-	var (
-		profileID = flag.String("profile", os.Getenv("NEXTDNS_PROFILE_ID"), "NextDNS Profile ID")
-		apiKey    = flag.String("apikey", os.Getenv("NEXTDNS_API_KEY"), "NextDNS API Key")
-		dbURL     = flag.String("db", os.Getenv("DATABASE_URL"), "PostgreSQL connection string")
-	)
-	flag.Parse()
-
-	if *profileID == "" || *apiKey == "" || *dbURL == "" {
-		log.Fatal("Missing required configuration. Set profile, apikey, and db flags or environment variables.")
+	// Loki labels a push by profile, so each profile gets its own batching
+	// client even though they all share one sink.
+	lokiClients := map[string]*loki.Client{}
+	if cli.LokiURL != "" {
+		for _, p := range profiles {
+			c := loki.NewClient(loki.Config{
+				URL:           cli.LokiURL,
+				TenantID:      cli.LokiTenant,
+				BasicAuthUser: cli.LokiUser,
+				BasicAuthPass: cli.LokiPass,
+				Profile:       p.ID,
+				BatchSize:     cli.LokiBatchSize,
+				MaxDelay:      cli.LokiMaxDelay,
+			})
+			defer c.Close()
+			lokiClients[p.ID] = c
+		}
 	}
 
-	config := &Config{
-		ProfileID: *profileID,
-		APIKey:    *apiKey,
-		DBURL:     *dbURL,
+	enricher, err := enrich.New(cli.GeoIPCity, cli.GeoIPASN)
+	if err != nil {
+		log.Fatalf("Failed to initialize GeoIP enrichment: %v", err)
 	}
+	defer enricher.Close()
+
+	m := metrics.New()
+	adminServer := metrics.NewServer(cli.MetricsAddr, cli.AdminToken, sink, m)
+	adminServer.Start()
+	defer adminServer.Close()
 
-	db, err := sql.Open("postgres", config.DBURL)
+	retentionRules, err := retention.ParseRules(cli.Retention)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Invalid -retention value: %v", err)
+	}
+	if len(retentionRules) > 0 {
+		worker := retention.NewWorker(sink, retentionRules, cli.RetentionInterval, 0, m)
+		retentionCtx, cancelRetention := context.WithCancel(context.Background())
+		defer cancelRetention()
+		go worker.Run(retentionCtx)
 	}
-	defer db.Close()
 
-	if err := initDB(db); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	var wal *buffer.Buffer
+	if cli.BufferDir != "" {
+		wal, err = buffer.Open(cli.BufferDir, sink, cli.BufferMaxSize)
+		if err != nil {
+			log.Fatalf("Failed to open write-ahead buffer: %v", err)
+		}
+		defer wal.Close()
 	}
 
-	log.Println("Starting NextDNS log sync...")
-	if err := syncLogs(config, db); err != nil {
-		log.Fatalf("Sync failed: %v", err)
+	log.Printf("Starting NextDNS log sync for %d profile(s)...", len(profiles))
+
+	// Each profile gets its own stream/pagination goroutine and its own
+	// "stream_id:<profile>" cursor, since they all share one sink.
+	var wg sync.WaitGroup
+	var failed int32
+	for _, p := range profiles {
+		profileConfig := &Config{ProfileID: p.ID, APIKey: p.APIKey}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := syncLogs(profileConfig, sink, lokiClients[profileConfig.ProfileID], enricher, m, wal); err != nil {
+				log.Printf("Sync failed for profile %s: %v", profileConfig.ProfileID, err)
+				atomic.AddInt32(&failed, 1)
+			}
+		}()
 	}
-}
+	wg.Wait()
 
-func initDB(db *sql.DB) error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS dns_logs (
-		id VARCHAR(255) PRIMARY KEY,
-		timestamp TIMESTAMP NOT NULL,
-		domain TEXT NOT NULL,
-		type VARCHAR(50),
-		status VARCHAR(50),
-		blocked BOOLEAN,
-		client_ip VARCHAR(50),
-		protocol VARCHAR(50),
-		device JSONB,
-		root TEXT,
-		tracker TEXT,
-		encrypted BOOLEAN,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-	CREATE INDEX IF NOT EXISTS idx_timestamp ON dns_logs(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_domain ON dns_logs(domain);
-	CREATE INDEX IF NOT EXISTS idx_root ON dns_logs(root);
-	
-	CREATE TABLE IF NOT EXISTS sync_state (
-		key VARCHAR(50) PRIMARY KEY,
-		value TEXT,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-	_, err := db.Exec(schema)
-	return err
+	if failed > 0 {
+		log.Fatalf("Sync failed for %d of %d profile(s)", failed, len(profiles))
+	}
 }
 
-func syncLogs(config *Config, db *sql.DB) error {
+func syncLogs(config *Config, sink storage.Sink, lokiClient *loki.Client, enricher *enrich.Enricher, m *metrics.Metrics, wal *buffer.Buffer) error {
 	ctx := context.Background()
 
 	// Try streaming first
-	streamID, err := getStreamCursor(db)
+	streamID, err := sink.GetCursor(config.ProfileID)
 	if err != nil {
 		log.Printf("Warning: could not get stream ID: %v", err)
 	}
@@ -147,8 +151,9 @@ func syncLogs(config *Config, db *sql.DB) error {
 		maxRetryDelay := 60 * time.Second
 
 		for {
-			err := streamLogs(ctx, config, db, streamID)
+			err := streamLogs(ctx, config, sink, lokiClient, enricher, m, wal, streamID)
 			if err != nil {
+				m.RecordReconnect()
 				log.Printf("Stream disconnected: %v", err)
 				log.Printf("Reconnecting in %v...", retryDelay)
 				time.Sleep(retryDelay)
@@ -160,7 +165,7 @@ func syncLogs(config *Config, db *sql.DB) error {
 				}
 
 				// Get the latest stream ID before reconnecting
-				streamID, err = getStreamCursor(db)
+				streamID, err = sink.GetCursor(config.ProfileID)
 				if err != nil {
 					log.Printf("Warning: could not get stream ID: %v", err)
 				}
@@ -176,10 +181,10 @@ func syncLogs(config *Config, db *sql.DB) error {
 	}
 
 	// Fallback to cursor-based pagination
-	return paginateLogs(ctx, config, db)
+	return paginateLogs(ctx, config, sink, lokiClient, enricher, m, wal)
 }
 
-func streamLogs(ctx context.Context, config *Config, db *sql.DB, streamID string) error {
+func streamLogs(ctx context.Context, config *Config, sink storage.Sink, lokiClient *loki.Client, enricher *enrich.Enricher, m *metrics.Metrics, wal *buffer.Buffer, streamID string) error {
 	url := fmt.Sprintf("https://api.nextdns.io/profiles/%s/logs/stream?id=%s", config.ProfileID, streamID)
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -189,7 +194,9 @@ func streamLogs(ctx context.Context, config *Config, db *sql.DB, streamID string
 	req.Header.Set("X-Api-Key", config.APIKey)
 
 	client := &http.Client{Timeout: 0} // No timeout for streaming
+	requestStart := time.Now()
 	resp, err := client.Do(req)
+	m.ObserveAPIRequest("stream", time.Since(requestStart))
 	if err != nil {
 		return err
 	}
@@ -202,7 +209,7 @@ func streamLogs(ctx context.Context, config *Config, db *sql.DB, streamID string
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Buffer(make([]byte, 1024*1024), 1024*1024) // 1MB buffer
 
-	log.Println("Connected to stream API, receiving logs...")
+	log.Printf("[%s] Connected to stream API, receiving logs...", config.ProfileID)
 	count := 0
 	currentStreamID := streamID
 	var lastEventID string
@@ -218,7 +225,7 @@ func streamLogs(ctx context.Context, config *Config, db *sql.DB, streamID string
 			select {
 			case <-ticker.C:
 				elapsed := time.Since(lastActivity)
-				log.Printf("Stream alive: %d lines, %d logs processed (last activity: %v ago)", lineCount, count, elapsed.Round(time.Second))
+				log.Printf("[%s] Stream alive: %d lines, %d logs processed (last activity: %v ago)", config.ProfileID, lineCount, count, elapsed.Round(time.Second))
 			case <-done:
 				return
 			}
@@ -230,6 +237,7 @@ func streamLogs(ctx context.Context, config *Config, db *sql.DB, streamID string
 		line := scanner.Text()
 		lineCount++
 		lastActivity = time.Now()
+		m.Touch()
 
 		if line == "" {
 			continue
@@ -238,41 +246,60 @@ func streamLogs(ctx context.Context, config *Config, db *sql.DB, streamID string
 		// Parse SSE format: "id: ..." or "data: ..."
 		if strings.HasPrefix(line, "id: ") {
 			lastEventID = strings.TrimPrefix(line, "id: ")
-			log.Printf("Received event ID: %s", lastEventID)
+			log.Printf("[%s] Received event ID: %s", config.ProfileID, lastEventID)
 			continue
 		}
 
 		if strings.HasPrefix(line, "data: ") {
 			dataJSON := strings.TrimPrefix(line, "data: ")
 
-			var logEntry DNSLog
+			var logEntry storage.DNSLog
 			if err := json.Unmarshal([]byte(dataJSON), &logEntry); err != nil {
-				log.Printf("Failed to parse log entry: %v", err)
+				log.Printf("[%s] Failed to parse log entry: %v", config.ProfileID, err)
 				continue
 			}
 
+			logEntry.Profile = config.ProfileID
 			logEntry.GenerateID() // Generate ID for streamed entry
 
-			if err := insertLog(db, &logEntry); err != nil {
-				if err == sql.ErrNoRows {
-					log.Printf("Duplicate log entry (domain: %s)", logEntry.Domain)
-				} else {
-					log.Printf("Failed to insert log: %v", err)
+			enrichLog(&logEntry, enricher)
+
+			if lokiClient != nil {
+				if err := lokiClient.Push(&logEntry); err != nil {
+					log.Printf("[%s] Warning: failed to queue log for Loki: %v", config.ProfileID, err)
 				}
+			}
+
+			insertStart := time.Now()
+			inserted, err := sink.Insert(&logEntry)
+			m.ObserveInsert(time.Since(insertStart))
+			if err != nil {
+				log.Printf("[%s] Failed to insert log: %v", config.ProfileID, err)
+				if wal != nil {
+					if bufErr := wal.Put(&logEntry); bufErr != nil {
+						log.Printf("[%s] Warning: failed to buffer log after insert failure: %v", config.ProfileID, bufErr)
+					}
+				}
+				continue
+			}
+			if !inserted {
+				m.RecordDuplicate()
+				log.Printf("[%s] Duplicate log entry (domain: %s)", config.ProfileID, logEntry.Domain)
 				continue
 			}
+			m.RecordInsert(logEntry.Status, logEntry.Blocked)
 
 			count++
-			log.Printf("âœ“ Inserted log #%d: %s -> %s", count, logEntry.Domain, logEntry.Status)
+			log.Printf("[%s] Inserted log #%d: %s -> %s", config.ProfileID, count, logEntry.Domain, logEntry.Status)
 
 			// Update stream ID with the last event ID we received
 			if lastEventID != "" {
 				currentStreamID = lastEventID
 				if count%100 == 0 {
-					if err := updateStreamCursor(db, currentStreamID); err != nil {
-						log.Printf("Warning: failed to update stream ID: %v", err)
+					if err := sink.SetCursor(config.ProfileID, currentStreamID); err != nil {
+						log.Printf("[%s] Warning: failed to update stream ID: %v", config.ProfileID, err)
 					} else {
-						log.Printf("Updated stream cursor to: %s", currentStreamID)
+						log.Printf("[%s] Updated stream cursor to: %s", config.ProfileID, currentStreamID)
 					}
 				}
 			}
@@ -281,9 +308,9 @@ func streamLogs(ctx context.Context, config *Config, db *sql.DB, streamID string
 
 	// Save the last event ID before exiting
 	if lastEventID != "" {
-		log.Printf("Saving final stream cursor: %s", lastEventID)
-		if err := updateStreamCursor(db, lastEventID); err != nil {
-			log.Printf("Warning: failed to update stream ID: %v", err)
+		log.Printf("[%s] Saving final stream cursor: %s", config.ProfileID, lastEventID)
+		if err := sink.SetCursor(config.ProfileID, lastEventID); err != nil {
+			log.Printf("[%s] Warning: failed to update stream ID: %v", config.ProfileID, err)
 		}
 	}
 
@@ -291,15 +318,18 @@ func streamLogs(ctx context.Context, config *Config, db *sql.DB, streamID string
 		return fmt.Errorf("stream error: %v", err)
 	}
 
-	log.Printf("Stream ended cleanly after processing %d logs", count)
+	log.Printf("[%s] Stream ended cleanly after processing %d logs", config.ProfileID, count)
 	return nil
 }
 
-func paginateLogs(ctx context.Context, config *Config, db *sql.DB) error {
+func paginateLogs(ctx context.Context, config *Config, sink storage.Sink, lokiClient *loki.Client, enricher *enrich.Enricher, m *metrics.Metrics, wal *buffer.Buffer) error {
 	cursor := ""
 	count := 0
 	totalInserted := 0
 
+	m.PaginationStarted()
+	defer m.PaginationFinished()
+
 	for {
 		url := fmt.Sprintf("https://api.nextdns.io/profiles/%s/logs?limit=1000", config.ProfileID)
 		if cursor != "" {
@@ -313,7 +343,9 @@ func paginateLogs(ctx context.Context, config *Config, db *sql.DB) error {
 		req.Header.Set("X-Api-Key", config.APIKey)
 
 		client := &http.Client{Timeout: 30 * time.Second}
+		requestStart := time.Now()
 		resp, err := client.Do(req)
+		m.ObserveAPIRequest("paginate", time.Since(requestStart))
 		if err != nil {
 			return err
 		}
@@ -324,7 +356,7 @@ func paginateLogs(ctx context.Context, config *Config, db *sql.DB) error {
 		}
 
 		var result struct {
-			Data []DNSLog `json:"data"`
+			Data []storage.DNSLog `json:"data"`
 			Meta struct {
 				Pagination struct {
 					Cursor string `json:"cursor"`
@@ -344,38 +376,57 @@ func paginateLogs(ctx context.Context, config *Config, db *sql.DB) error {
 		if len(result.Data) == 0 {
 			break
 		}
+		m.Touch()
 
 		inserted := 0
 		duplicates := 0
 		for _, logEntry := range result.Data {
-			if err := insertLog(db, &logEntry); err != nil {
-				if err == sql.ErrNoRows {
-					// This was a duplicate
-					duplicates++
-					continue
+			logEntry.Profile = config.ProfileID
+			enrichLog(&logEntry, enricher)
+
+			if lokiClient != nil {
+				if err := lokiClient.Push(&logEntry); err != nil {
+					log.Printf("[%s] Warning: failed to queue log for Loki: %v", config.ProfileID, err)
 				}
-				log.Printf("Error inserting log %s: %v", logEntry.ID, err)
+			}
+
+			insertStart := time.Now()
+			ok, err := sink.Insert(&logEntry)
+			m.ObserveInsert(time.Since(insertStart))
+			if err != nil {
+				log.Printf("[%s] Error inserting log %s: %v", config.ProfileID, logEntry.ID, err)
+				if wal != nil {
+					if bufErr := wal.Put(&logEntry); bufErr != nil {
+						log.Printf("[%s] Warning: failed to buffer log after insert failure: %v", config.ProfileID, bufErr)
+					}
+				}
+				continue
+			}
+			if !ok {
+				m.RecordDuplicate()
+				duplicates++
 				continue
 			}
+			m.RecordInsert(logEntry.Status, logEntry.Blocked)
 			inserted++
 		}
 
 		totalInserted += inserted
 		count += len(result.Data)
-		log.Printf("Fetched %d logs (%d new, %d duplicates), total new: %d",
-			len(result.Data), inserted, duplicates, totalInserted)
+		log.Printf("[%s] Fetched %d logs (%d new, %d duplicates), total new: %d",
+			config.ProfileID, len(result.Data), inserted, duplicates, totalInserted)
 
 		// If we're seeing all duplicates, we've caught up
 		if inserted == 0 && duplicates == len(result.Data) {
-			log.Println("All logs are duplicates, caught up with existing data")
+			log.Printf("[%s] All logs are duplicates, caught up with existing data", config.ProfileID)
 			break
 		}
 
 		// Save the stream ID from the first response for future streaming
 		if cursor == "" && result.Meta.Stream.ID != "" {
-			log.Printf("Saving stream ID for future use: %s", result.Meta.Stream.ID)
-			if err := updateStreamCursor(db, result.Meta.Stream.ID); err != nil {
-				log.Printf("Warning: failed to update stream cursor: %v", err)
+			log.Printf("[%s] Saving stream ID for future use: %s", config.ProfileID, result.Meta.Stream.ID)
+			if err := sink.SetCursor(config.ProfileID, result.Meta.Stream.ID); err != nil {
+				log.Printf("[%s] Warning: failed to update stream cursor: %v", config.ProfileID, err)
 			}
 		}
 
@@ -388,66 +439,18 @@ func paginateLogs(ctx context.Context, config *Config, db *sql.DB) error {
 		time.Sleep(100 * time.Millisecond)
 	}
 
-	log.Printf("Pagination complete. Total new logs inserted: %d", totalInserted)
-	return nil
-}
-
-func insertLog(db *sql.DB, log *DNSLog) error {
-	// Generate ID if not already set
-	if log.ID == "" {
-		log.GenerateID()
-	}
-
-	query := `
-		INSERT INTO dns_logs (id, timestamp, domain, type, status, blocked, client_ip, protocol, device, root, tracker, encrypted)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-		ON CONFLICT (id) DO NOTHING
-	`
-	deviceJSON := string(log.Device)
-	if deviceJSON == "" {
-		deviceJSON = "null"
-	}
-	result, err := db.Exec(query, log.ID, log.Timestamp, log.Domain, log.Type, log.Status,
-		log.Blocked, log.ClientIP, log.Protocol, deviceJSON, log.Root, log.Tracker, log.Encrypted)
-	if err != nil {
-		return err
-	}
-
-	// Check if row was actually inserted (not a duplicate)
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-
-	if rows == 0 {
-		return sql.ErrNoRows // Signal this was a duplicate
-	}
-
+	log.Printf("[%s] Pagination complete. Total new logs inserted: %d", config.ProfileID, totalInserted)
 	return nil
 }
 
-func getStreamCursor(db *sql.DB) (string, error) {
-	var cursor string
-	err := db.QueryRow("SELECT value FROM sync_state WHERE key = 'stream_id'").Scan(&cursor)
-	if err == sql.ErrNoRows {
-		return "", nil
-	}
-	return cursor, err
-}
-
-func updateStreamCursor(db *sql.DB, cursor string) error {
-	query := `
-		INSERT INTO sync_state (key, value, updated_at)
-		VALUES ('stream_id', $1, CURRENT_TIMESTAMP)
-		ON CONFLICT (key) DO UPDATE SET value = $1, updated_at = CURRENT_TIMESTAMP
-	`
-	_, err := db.Exec(query, cursor)
-	return err
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
+// enrichLog resolves logEntry.ClientIP against enricher and copies the
+// result onto the log entry's GeoIP fields.
+func enrichLog(logEntry *storage.DNSLog, enricher *enrich.Enricher) {
+	info := enricher.Lookup(logEntry.ClientIP)
+	logEntry.Country = info.Country
+	logEntry.City = info.City
+	logEntry.Latitude = info.Latitude
+	logEntry.Longitude = info.Longitude
+	logEntry.ASN = info.ASN
+	logEntry.ASNOrg = info.ASNOrg
 }
@@ -0,0 +1,163 @@
+// Package metrics exposes Prometheus instrumentation for the sync process,
+// plus the small bits of liveness state that /healthz needs but that don't
+// fit naturally as a gauge value.
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the Prometheus collectors for a sync run.
+type Metrics struct {
+	LogsInserted       *prometheus.CounterVec
+	LogsDuplicate      prometheus.Counter
+	StreamReconnects   prometheus.Counter
+	StreamLastEventAge prometheus.GaugeFunc
+	APIRequestDuration *prometheus.HistogramVec
+	InsertDuration     prometheus.Histogram
+
+	RetentionRowsDeleted prometheus.Counter
+	RetentionLastRun     prometheus.Gauge
+
+	registry *prometheus.Registry
+
+	mu           sync.RWMutex
+	lastActivity time.Time
+	paginating   int
+}
+
+// New creates a Metrics instance registered against its own registry,
+// rather than the global prometheus.DefaultRegisterer, so multiple
+// instances (e.g. in tests) don't collide.
+func New() *Metrics {
+	m := &Metrics{registry: prometheus.NewRegistry()}
+
+	m.LogsInserted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nextdns_sync_logs_inserted_total",
+		Help: "Total number of DNS log entries inserted into the sink.",
+	}, []string{"status", "blocked"})
+
+	m.LogsDuplicate = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nextdns_sync_logs_duplicate_total",
+		Help: "Total number of DNS log entries skipped as duplicates.",
+	})
+
+	m.StreamReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nextdns_sync_stream_reconnects_total",
+		Help: "Total number of stream API reconnect attempts after a disconnect.",
+	})
+
+	m.StreamLastEventAge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "nextdns_sync_stream_last_event_age_seconds",
+		Help: "Seconds since the last stream event or pagination fetch was processed.",
+	}, m.lastActivityAge)
+
+	m.APIRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "nextdns_sync_api_request_duration_seconds",
+		Help: "Duration of NextDNS API requests, labeled by call (stream, paginate).",
+	}, []string{"call"})
+
+	m.InsertDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "nextdns_sync_insert_duration_seconds",
+		Help: "Duration of sink.Insert calls.",
+	})
+
+	m.RetentionRowsDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nextdns_sync_retention_rows_deleted_total",
+		Help: "Total number of DNS log rows deleted by the retention worker.",
+	})
+
+	m.RetentionLastRun = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nextdns_sync_retention_last_run_timestamp_seconds",
+		Help: "Unix timestamp of the last completed retention sweep.",
+	})
+
+	m.registry.MustRegister(m.LogsInserted, m.LogsDuplicate, m.StreamReconnects,
+		m.StreamLastEventAge, m.APIRequestDuration, m.InsertDuration,
+		m.RetentionRowsDeleted, m.RetentionLastRun)
+
+	return m
+}
+
+// RecordInsert records a successfully inserted log entry.
+func (m *Metrics) RecordInsert(status string, blocked bool) {
+	m.LogsInserted.WithLabelValues(status, strconv.FormatBool(blocked)).Inc()
+}
+
+// RecordDuplicate records a log entry that was skipped as a duplicate.
+func (m *Metrics) RecordDuplicate() {
+	m.LogsDuplicate.Inc()
+}
+
+// RecordReconnect records a stream reconnect attempt.
+func (m *Metrics) RecordReconnect() {
+	m.StreamReconnects.Inc()
+}
+
+// ObserveAPIRequest records how long an API call took, labeled by call
+// ("stream" or "paginate").
+func (m *Metrics) ObserveAPIRequest(call string, d time.Duration) {
+	m.APIRequestDuration.WithLabelValues(call).Observe(d.Seconds())
+}
+
+// ObserveInsert records how long a sink.Insert call took.
+func (m *Metrics) ObserveInsert(d time.Duration) {
+	m.InsertDuration.Observe(d.Seconds())
+}
+
+// Touch records that a stream event or pagination page was just processed.
+func (m *Metrics) Touch() {
+	m.mu.Lock()
+	m.lastActivity = time.Now()
+	m.mu.Unlock()
+}
+
+// PaginationStarted records that a pagination sweep has begun. It's a
+// counter rather than a bool because several profiles can be paginating
+// concurrently against the same Metrics; one profile's sweep finishing
+// must not clear the in-progress state for another's.
+func (m *Metrics) PaginationStarted() {
+	m.mu.Lock()
+	m.paginating++
+	m.mu.Unlock()
+}
+
+// PaginationFinished records that a pagination sweep has ended.
+func (m *Metrics) PaginationFinished() {
+	m.mu.Lock()
+	m.paginating--
+	m.mu.Unlock()
+}
+
+// Healthy reports whether the stream has seen activity within maxAge, or a
+// pagination sweep is currently running.
+func (m *Metrics) Healthy(maxAge time.Duration) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.paginating > 0 {
+		return true
+	}
+	if m.lastActivity.IsZero() {
+		return false
+	}
+	return time.Since(m.lastActivity) <= maxAge
+}
+
+// RecordRetentionSweep records the outcome of a completed retention sweep.
+func (m *Metrics) RecordRetentionSweep(rowsDeleted int64, at time.Time) {
+	m.RetentionRowsDeleted.Add(float64(rowsDeleted))
+	m.RetentionLastRun.Set(float64(at.Unix()))
+}
+
+func (m *Metrics) lastActivityAge() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.lastActivity.IsZero() {
+		return 0
+	}
+	return time.Since(m.lastActivity).Seconds()
+}
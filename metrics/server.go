@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/enxoco/nextdns-sync/storage"
+)
+
+// maxStreamAge is how long the stream (or pagination) can go quiet before
+// /healthz reports unhealthy.
+const maxStreamAge = 5 * time.Minute
+
+// Server is the internal HTTP server exposing Prometheus metrics, health
+// checks, and, when an admin token is configured, debug endpoints.
+type Server struct {
+	sink       storage.Sink
+	metrics    *Metrics
+	adminToken string
+	httpServer *http.Server
+}
+
+// NewServer builds the admin/metrics server. addr is the listen address,
+// e.g. ":9090". adminToken gates the /debug endpoints; leave it empty to
+// disable them entirely.
+func NewServer(addr, adminToken string, sink storage.Sink, m *Metrics) *Server {
+	s := &Server{sink: sink, metrics: m, adminToken: adminToken}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/debug/cursor", s.requireAdmin(s.handleDebugCursor))
+
+	s.httpServer = &http.Server{Addr: addr, Handler: mux}
+	return s
+}
+
+// Start begins serving in the background. Listener errors are logged
+// rather than returned, since the caller has nothing useful to do with
+// them beyond what's already in the log.
+func (s *Server) Start() {
+	go func() {
+		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin server error: %v", err)
+		}
+	}()
+}
+
+// Close gracefully shuts down the server.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.httpServer.Shutdown(ctx)
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !s.metrics.Healthy(maxStreamAge) {
+		http.Error(w, "stale: no stream activity or pagination sweep recently", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.sink.GetCursor(""); err != nil {
+		http.Error(w, "db unreachable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// requireAdmin wraps next so it 403s unless called with a matching
+// X-Admin-Token header. With no admin token configured, the endpoint is
+// disabled outright. The header is compared in constant time since this
+// is effectively a bearer token check.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := r.Header.Get("X-Admin-Token")
+		if s.adminToken == "" || subtle.ConstantTimeCompare([]byte(got), []byte(s.adminToken)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleDebugCursor reads or writes the stream cursor for a profile
+// directly, without needing SQL access to the sync_state table. The
+// profile is given as a "profile" query parameter, since one process may
+// be syncing several NextDNS profiles concurrently.
+func (s *Server) handleDebugCursor(w http.ResponseWriter, r *http.Request) {
+	profile := r.URL.Query().Get("profile")
+
+	switch r.Method {
+	case http.MethodGet:
+		cursor, err := s.sink.GetCursor(profile)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"cursor": cursor})
+	case http.MethodPost, http.MethodPut:
+		var body struct {
+			Cursor string `json:"cursor"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.sink.SetCursor(profile, body.Cursor); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,106 @@
+// Package retention runs a background worker, modeled on discosrv's
+// cleansrv, that periodically deletes old rows from the dns_logs table.
+package retention
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/enxoco/nextdns-sync/metrics"
+	"github.com/enxoco/nextdns-sync/storage"
+)
+
+// defaultBatchSize bounds how many rows a single DeleteOlderThan call
+// removes, so a large sweep doesn't hold locks over the whole range.
+const defaultBatchSize = 10000
+
+// Rule says logs of Status (or, if Status is "", any status not covered by
+// a more specific rule) should be deleted once older than MaxAge.
+type Rule struct {
+	Status string
+	MaxAge time.Duration
+}
+
+// Worker periodically sweeps a Sink, deleting rows older than the
+// configured per-status retention windows.
+type Worker struct {
+	sink      storage.Sink
+	rules     []Rule
+	interval  time.Duration
+	batchSize int
+	metrics   *metrics.Metrics
+}
+
+// NewWorker builds a retention worker. interval <= 0 defaults to 1h;
+// batchSize <= 0 defaults to defaultBatchSize.
+func NewWorker(sink storage.Sink, rules []Rule, interval time.Duration, batchSize int, m *metrics.Metrics) *Worker {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	return &Worker{sink: sink, rules: rules, interval: interval, batchSize: batchSize, metrics: m}
+}
+
+// Run sweeps immediately, then on every tick of the configured interval,
+// until ctx is canceled.
+func (w *Worker) Run(ctx context.Context) {
+	w.sweep()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.sweep()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *Worker) sweep() {
+	var explicitStatuses []string
+	for _, r := range w.rules {
+		if r.Status != "" {
+			explicitStatuses = append(explicitStatuses, r.Status)
+		}
+	}
+
+	var total int64
+	for _, rule := range w.rules {
+		var exclude []string
+		if rule.Status == "" {
+			exclude = explicitStatuses
+		}
+
+		before := time.Now().Add(-rule.MaxAge)
+		for {
+			n, err := w.sink.DeleteOlderThan(before, rule.Status, exclude, w.batchSize)
+			if err != nil {
+				log.Printf("Retention sweep failed for status %q: %v", ruleLabel(rule.Status), err)
+				break
+			}
+			total += n
+			if n < int64(w.batchSize) {
+				break
+			}
+		}
+	}
+
+	if total > 0 {
+		log.Printf("Retention sweep complete: %d rows deleted", total)
+	}
+	if w.metrics != nil {
+		w.metrics.RecordRetentionSweep(total, time.Now())
+	}
+}
+
+func ruleLabel(status string) string {
+	if status == "" {
+		return "default"
+	}
+	return status
+}
@@ -0,0 +1,62 @@
+package retention
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseRules parses a -retention flag value into a rule set. spec is
+// either a bare duration applied to every status ("90d"), or a
+// comma-separated list of status=duration pairs, where the special status
+// "default" matches any log whose status has no more specific rule
+// ("allowed=30d,blocked=365d,default=90d"). An empty spec yields no rules,
+// which callers should treat as "retention disabled".
+func ParseRules(spec string) ([]Rule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(spec, ",")
+	rules := make([]Rule, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		status := ""
+		durationStr := part
+		if eq := strings.IndexByte(part, '='); eq >= 0 {
+			status = strings.TrimSpace(part[:eq])
+			durationStr = strings.TrimSpace(part[eq+1:])
+			if status == "default" {
+				status = ""
+			}
+		}
+
+		age, err := parseDuration(durationStr)
+		if err != nil {
+			return nil, fmt.Errorf("retention rule %q: %w", part, err)
+		}
+		rules = append(rules, Rule{Status: status, MaxAge: age})
+	}
+
+	return rules, nil
+}
+
+// parseDuration extends time.ParseDuration with a "d" (day) unit, since
+// retention windows are naturally expressed in days ("90d") rather than
+// hours.
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %w", s, err)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,93 @@
+package retention
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRulesEmpty(t *testing.T) {
+	rules, err := ParseRules("")
+	if err != nil {
+		t.Fatalf("ParseRules(\"\") returned error: %v", err)
+	}
+	if rules != nil {
+		t.Fatalf("ParseRules(\"\") = %v, want nil", rules)
+	}
+}
+
+func TestParseRulesBareDuration(t *testing.T) {
+	rules, err := ParseRules("90d")
+	if err != nil {
+		t.Fatalf("ParseRules returned error: %v", err)
+	}
+	want := []Rule{{Status: "", MaxAge: 90 * 24 * time.Hour}}
+	if len(rules) != 1 || rules[0] != want[0] {
+		t.Fatalf("ParseRules(\"90d\") = %+v, want %+v", rules, want)
+	}
+}
+
+func TestParseRulesPerStatus(t *testing.T) {
+	rules, err := ParseRules("allowed=30d,blocked=365d,default=90d")
+	if err != nil {
+		t.Fatalf("ParseRules returned error: %v", err)
+	}
+	want := []Rule{
+		{Status: "allowed", MaxAge: 30 * 24 * time.Hour},
+		{Status: "blocked", MaxAge: 365 * 24 * time.Hour},
+		{Status: "", MaxAge: 90 * 24 * time.Hour},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("ParseRules returned %d rules, want %d: %+v", len(rules), len(want), rules)
+	}
+	for i, r := range rules {
+		if r != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, r, want[i])
+		}
+	}
+}
+
+func TestParseRulesWhitespaceAndBlankEntries(t *testing.T) {
+	rules, err := ParseRules(" allowed = 1h , , blocked=2h ")
+	if err != nil {
+		t.Fatalf("ParseRules returned error: %v", err)
+	}
+	want := []Rule{
+		{Status: "allowed", MaxAge: time.Hour},
+		{Status: "blocked", MaxAge: 2 * time.Hour},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("ParseRules returned %d rules, want %d: %+v", len(rules), len(want), rules)
+	}
+	for i, r := range rules {
+		if r != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, r, want[i])
+		}
+	}
+}
+
+func TestParseRulesInvalidDuration(t *testing.T) {
+	if _, err := ParseRules("allowed=notaduration"); err == nil {
+		t.Fatal("ParseRules with an invalid duration should have returned an error")
+	}
+}
+
+func TestParseDurationDayUnit(t *testing.T) {
+	got, err := parseDuration("2.5d")
+	if err != nil {
+		t.Fatalf("parseDuration returned error: %v", err)
+	}
+	want := time.Duration(2.5 * float64(24*time.Hour))
+	if got != want {
+		t.Fatalf("parseDuration(\"2.5d\") = %v, want %v", got, want)
+	}
+}
+
+func TestParseDurationStandardUnit(t *testing.T) {
+	got, err := parseDuration("90m")
+	if err != nil {
+		t.Fatalf("parseDuration returned error: %v", err)
+	}
+	if got != 90*time.Minute {
+		t.Fatalf("parseDuration(\"90m\") = %v, want %v", got, 90*time.Minute)
+	}
+}
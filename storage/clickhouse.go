@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// ClickHouse is a Sink backed by ClickHouse, for users who want analytical
+// querying over millions of DNS logs. ClickHouse has no native upsert, so
+// dedup is done with an existence check rather than an ON CONFLICT clause;
+// the dns_logs table uses ReplacingMergeTree as a backstop against races.
+type ClickHouse struct {
+	db *sql.DB
+
+	// mu serializes Insert, since the dedup check and the insert it guards
+	// are two separate statements: without this lock, two callers racing
+	// on overlapping data could both see "not found" and both insert, and
+	// ReplacingMergeTree only reconciles the duplicate rows asynchronously
+	// later -- it wouldn't make the returned inserted bool correct for the
+	// metrics/WAL-retry decisions callers make on it.
+	mu sync.Mutex
+}
+
+// NewClickHouse opens a connection to the given ClickHouse DSN.
+func NewClickHouse(dsn string) (*ClickHouse, error) {
+	db, err := sql.Open("clickhouse", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &ClickHouse{db: db}, nil
+}
+
+func (c *ClickHouse) Init() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS dns_logs (
+		id String,
+		profile String,
+		timestamp DateTime64(3),
+		domain String,
+		type String,
+		status String,
+		blocked UInt8,
+		client_ip String,
+		protocol String,
+		device String,
+		root String,
+		tracker String,
+		encrypted UInt8,
+		country String,
+		city String,
+		latitude Float64,
+		longitude Float64,
+		asn UInt32,
+		asn_org String,
+		created_at DateTime DEFAULT now()
+	) ENGINE = ReplacingMergeTree(created_at)
+	ORDER BY (id);
+
+	CREATE TABLE IF NOT EXISTS sync_state (
+		key String,
+		value String,
+		updated_at DateTime DEFAULT now()
+	) ENGINE = ReplacingMergeTree(updated_at)
+	ORDER BY (key);
+	`
+	_, err := c.db.Exec(schema)
+	return err
+}
+
+func (c *ClickHouse) Insert(log *DNSLog) (bool, error) {
+	if log.ID == "" {
+		log.GenerateID()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var exists uint8
+	err := c.db.QueryRow("SELECT 1 FROM dns_logs WHERE id = $1 LIMIT 1", log.ID).Scan(&exists)
+	if err == nil {
+		return false, nil
+	}
+	if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	query := `
+		INSERT INTO dns_logs (id, profile, timestamp, domain, type, status, blocked, client_ip, protocol, device, root, tracker, encrypted, country, city, latitude, longitude, asn, asn_org)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+	`
+	deviceJSON := string(log.Device)
+	if deviceJSON == "" {
+		deviceJSON = "null"
+	}
+	if _, err := c.db.Exec(query, log.ID, log.Profile, log.Timestamp, log.Domain, log.Type, log.Status,
+		log.Blocked, log.ClientIP, log.Protocol, deviceJSON, log.Root, log.Tracker, log.Encrypted,
+		log.Country, log.City, log.Latitude, log.Longitude, log.ASN, log.ASNOrg); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *ClickHouse) GetCursor(profile string) (string, error) {
+	var cursor string
+	err := c.db.QueryRow("SELECT value FROM sync_state WHERE key = $1 ORDER BY updated_at DESC LIMIT 1", cursorKey(profile)).Scan(&cursor)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return cursor, err
+}
+
+func (c *ClickHouse) SetCursor(profile, cursor string) error {
+	_, err := c.db.Exec("INSERT INTO sync_state (key, value, updated_at) VALUES ($1, $2, now())", cursorKey(profile), cursor)
+	return err
+}
+
+// DeleteOlderThan deletes matching rows via an ALTER TABLE ... DELETE
+// mutation. ClickHouse mutations run asynchronously in the background and
+// have no WHERE ... LIMIT support, so unlike the other backends this
+// doesn't bound how many rows a single call actually removes -- it counts
+// the matching rows up front (capped at limit) purely so the caller still
+// gets a meaningful number to log and report as a metric.
+func (c *ClickHouse) DeleteOlderThan(before time.Time, status string, excludeStatuses []string, limit int) (int64, error) {
+	args := []interface{}{before}
+	where := "timestamp < $1"
+
+	if status != "" {
+		args = append(args, status)
+		where += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	for _, s := range excludeStatuses {
+		args = append(args, s)
+		where += fmt.Sprintf(" AND status != $%d", len(args))
+	}
+
+	var count int64
+	countQuery := fmt.Sprintf("SELECT count() FROM dns_logs WHERE %s", where)
+	if err := c.db.QueryRow(countQuery, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	if count > int64(limit) {
+		count = int64(limit)
+	}
+
+	deleteQuery := fmt.Sprintf("ALTER TABLE dns_logs DELETE WHERE %s", where)
+	if _, err := c.db.Exec(deleteQuery, args...); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (c *ClickHouse) Close() error {
+	return c.db.Close()
+}
@@ -0,0 +1,32 @@
+package storage
+
+import "time"
+
+// Null is a no-op Sink that neither persists logs nor tracks cursor state.
+// It lets nextdns-sync run with no database at all -- e.g. as a pure
+// NextDNS-to-Loki gateway -- by treating every log as newly inserted.
+type Null struct{}
+
+// NewNull returns a Sink that discards everything it's given.
+func NewNull() (*Null, error) {
+	return &Null{}, nil
+}
+
+func (n *Null) Init() error { return nil }
+
+func (n *Null) Insert(log *DNSLog) (bool, error) {
+	if log.ID == "" {
+		log.GenerateID()
+	}
+	return true, nil
+}
+
+func (n *Null) GetCursor(profile string) (string, error) { return "", nil }
+
+func (n *Null) SetCursor(profile, cursor string) error { return nil }
+
+func (n *Null) DeleteOlderThan(before time.Time, status string, excludeStatuses []string, limit int) (int64, error) {
+	return 0, nil
+}
+
+func (n *Null) Close() error { return nil }
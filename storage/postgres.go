@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// Postgres is a Sink backed by a PostgreSQL database.
+type Postgres struct {
+	db *sql.DB
+}
+
+// NewPostgres opens a connection to the given PostgreSQL DSN.
+func NewPostgres(dsn string) (*Postgres, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Postgres{db: db}, nil
+}
+
+func (p *Postgres) Init() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS dns_logs (
+		id VARCHAR(255) PRIMARY KEY,
+		profile VARCHAR(64) NOT NULL DEFAULT '',
+		timestamp TIMESTAMP NOT NULL,
+		domain TEXT NOT NULL,
+		type VARCHAR(50),
+		status VARCHAR(50),
+		blocked BOOLEAN,
+		client_ip VARCHAR(50),
+		protocol VARCHAR(50),
+		device JSONB,
+		root TEXT,
+		tracker TEXT,
+		encrypted BOOLEAN,
+		country VARCHAR(2),
+		city TEXT,
+		latitude DOUBLE PRECISION,
+		longitude DOUBLE PRECISION,
+		asn BIGINT,
+		asn_org TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_timestamp ON dns_logs(timestamp);
+	CREATE INDEX IF NOT EXISTS idx_domain ON dns_logs(domain);
+	CREATE INDEX IF NOT EXISTS idx_root ON dns_logs(root);
+	CREATE INDEX IF NOT EXISTS idx_profile ON dns_logs(profile);
+
+	CREATE TABLE IF NOT EXISTS sync_state (
+		key VARCHAR(50) PRIMARY KEY,
+		value TEXT,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	_, err := p.db.Exec(schema)
+	return err
+}
+
+func (p *Postgres) Insert(log *DNSLog) (bool, error) {
+	if log.ID == "" {
+		log.GenerateID()
+	}
+
+	query := `
+		INSERT INTO dns_logs (id, profile, timestamp, domain, type, status, blocked, client_ip, protocol, device, root, tracker, encrypted, country, city, latitude, longitude, asn, asn_org)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+		ON CONFLICT (id) DO NOTHING
+	`
+	deviceJSON := string(log.Device)
+	if deviceJSON == "" {
+		deviceJSON = "null"
+	}
+	result, err := p.db.Exec(query, log.ID, log.Profile, log.Timestamp, log.Domain, log.Type, log.Status,
+		log.Blocked, log.ClientIP, log.Protocol, deviceJSON, log.Root, log.Tracker, log.Encrypted,
+		log.Country, log.City, log.Latitude, log.Longitude, log.ASN, log.ASNOrg)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+func (p *Postgres) GetCursor(profile string) (string, error) {
+	var cursor string
+	err := p.db.QueryRow("SELECT value FROM sync_state WHERE key = $1", cursorKey(profile)).Scan(&cursor)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return cursor, err
+}
+
+func (p *Postgres) SetCursor(profile, cursor string) error {
+	query := `
+		INSERT INTO sync_state (key, value, updated_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (key) DO UPDATE SET value = $2, updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := p.db.Exec(query, cursorKey(profile), cursor)
+	return err
+}
+
+func (p *Postgres) DeleteOlderThan(before time.Time, status string, excludeStatuses []string, limit int) (int64, error) {
+	args := []interface{}{before}
+	where := "timestamp < $1"
+
+	if status != "" {
+		args = append(args, status)
+		where += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	for _, s := range excludeStatuses {
+		args = append(args, s)
+		where += fmt.Sprintf(" AND status != $%d", len(args))
+	}
+	args = append(args, limit)
+
+	// Postgres' DELETE has no LIMIT clause, so bound the batch with a
+	// subquery instead -- this is what keeps a large sweep from holding
+	// locks on the whole matching range at once.
+	query := fmt.Sprintf(`
+		DELETE FROM dns_logs WHERE id IN (
+			SELECT id FROM dns_logs WHERE %s LIMIT $%d
+		)
+	`, where, len(args))
+
+	result, err := p.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (p *Postgres) Close() error {
+	return p.db.Close()
+}
@@ -0,0 +1,121 @@
+// Package storage defines the pluggable Sink interface used to persist
+// NextDNS logs and stream cursor state, along with the concrete backend
+// implementations (postgres, sqlite, clickhouse).
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Device is the NextDNS device object embedded in a log entry.
+type Device struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// DNSLog represents a single DNS query log entry returned by the NextDNS API.
+type DNSLog struct {
+	ID      string `json:"-"` // Generated, not from API
+	Profile string `json:"-"` // NextDNS profile ID, set by the caller before Insert
+
+	Timestamp time.Time       `json:"timestamp"`
+	Domain    string          `json:"domain"` // Changed from "name"
+	Type      string          `json:"type"`
+	Status    string          `json:"status"`
+	Blocked   bool            `json:"blocked"`
+	ClientIP  string          `json:"clientIp"`
+	Protocol  string          `json:"protocol"`
+	Device    json.RawMessage `json:"device"`
+	Root      string          `json:"root"`
+	Tracker   string          `json:"tracker"`
+	Encrypted bool            `json:"encrypted"`
+
+	// The fields below aren't present in the NextDNS API response; they're
+	// populated from ClientIP by the enrich package before Insert, and left
+	// zero-valued when enrichment is disabled or the lookup misses.
+	Country   string  `json:"-"`
+	City      string  `json:"-"`
+	Latitude  float64 `json:"-"`
+	Longitude float64 `json:"-"`
+	ASN       uint    `json:"-"`
+	ASNOrg    string  `json:"-"`
+}
+
+// GenerateID creates a unique ID from profile, timestamp, domain, and
+// client IP. Profile is folded in so two profiles logging the same
+// domain/client-IP in the same nanosecond don't collide and get
+// silently treated as duplicates of each other.
+func (l *DNSLog) GenerateID() {
+	l.ID = fmt.Sprintf("%d-%s-%s-%s", l.Timestamp.UnixNano(), l.Domain, l.ClientIP, l.Profile)
+}
+
+// DeviceName extracts the device name from the raw device field, which the
+// NextDNS API sends as either an object or a bare string.
+func (l *DNSLog) DeviceName() string {
+	if len(l.Device) == 0 {
+		return ""
+	}
+	// Try to parse as object
+	var device Device
+	if err := json.Unmarshal(l.Device, &device); err == nil {
+		return device.Name
+	}
+	// Try as string
+	var deviceStr string
+	if err := json.Unmarshal(l.Device, &deviceStr); err == nil {
+		return deviceStr
+	}
+	return ""
+}
+
+// Sink is a pluggable storage backend for DNS logs and sync cursor state.
+// Insert reports whether the log was newly inserted; false means it was a
+// duplicate (keyed by DNSLog.ID), so callers never need to type-assert on an
+// engine-specific error like sql.ErrNoRows to detect conflicts.
+type Sink interface {
+	// Init creates the schema if it doesn't already exist.
+	Init() error
+	// Insert stores a log entry, generating its ID if unset. It returns
+	// false (with a nil error) when the entry already existed.
+	Insert(log *DNSLog) (inserted bool, err error)
+	// GetCursor returns the last saved stream cursor for profile, or "" if
+	// none exists. Cursor state is keyed per profile so a single sink can
+	// back several concurrently-synced NextDNS profiles.
+	GetCursor(profile string) (string, error)
+	// SetCursor persists the stream cursor for profile.
+	SetCursor(profile, cursor string) error
+	// DeleteOlderThan deletes log rows with timestamp before before, for use
+	// by a retention worker. If status is non-empty, only that status is
+	// considered; any status in excludeStatuses is skipped regardless (used
+	// to let a "default" rule apply to everything not covered by a more
+	// specific rule). At most limit rows are deleted, so callers can sweep
+	// in bounded batches; it returns how many rows were actually removed.
+	DeleteOlderThan(before time.Time, status string, excludeStatuses []string, limit int) (int64, error)
+	// Close releases any resources held by the sink.
+	Close() error
+}
+
+// cursorKey derives the sync_state key for a profile's stream cursor, so
+// one sink can track the cursor for several profiles at once.
+func cursorKey(profile string) string {
+	return "stream_id:" + profile
+}
+
+// Open opens a Sink for the given backend name. dsn is interpreted by each
+// backend (a PostgreSQL/ClickHouse connection string, or a SQLite file path).
+func Open(backend, dsn string) (Sink, error) {
+	switch backend {
+	case "postgres", "":
+		return NewPostgres(dsn)
+	case "sqlite":
+		return NewSQLite(dsn)
+	case "clickhouse":
+		return NewClickHouse(dsn)
+	case "none":
+		return NewNull()
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (want postgres, sqlite, clickhouse, or none)", backend)
+	}
+}
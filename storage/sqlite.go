@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLite is a Sink backed by a local SQLite database file, useful for
+// single-binary local use without standing up a PostgreSQL server.
+type SQLite struct {
+	db *sql.DB
+}
+
+// NewSQLite opens (and creates, if missing) the SQLite database at path.
+// The connection pool is capped at one connection, since SQLite rejects
+// concurrent writers outright -- this serializes writes (e.g. from
+// several profiles syncing against the same file) instead of surfacing
+// "database is locked" errors.
+func NewSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return &SQLite{db: db}, nil
+}
+
+func (s *SQLite) Init() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS dns_logs (
+		id TEXT PRIMARY KEY,
+		profile TEXT NOT NULL DEFAULT '',
+		timestamp TIMESTAMP NOT NULL,
+		domain TEXT NOT NULL,
+		type TEXT,
+		status TEXT,
+		blocked INTEGER,
+		client_ip TEXT,
+		protocol TEXT,
+		device TEXT,
+		root TEXT,
+		tracker TEXT,
+		encrypted INTEGER,
+		country TEXT,
+		city TEXT,
+		latitude REAL,
+		longitude REAL,
+		asn INTEGER,
+		asn_org TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_timestamp ON dns_logs(timestamp);
+	CREATE INDEX IF NOT EXISTS idx_domain ON dns_logs(domain);
+	CREATE INDEX IF NOT EXISTS idx_root ON dns_logs(root);
+	CREATE INDEX IF NOT EXISTS idx_profile ON dns_logs(profile);
+
+	CREATE TABLE IF NOT EXISTS sync_state (
+		key TEXT PRIMARY KEY,
+		value TEXT,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	_, err := s.db.Exec(schema)
+	return err
+}
+
+func (s *SQLite) Insert(log *DNSLog) (bool, error) {
+	if log.ID == "" {
+		log.GenerateID()
+	}
+
+	query := `
+		INSERT INTO dns_logs (id, profile, timestamp, domain, type, status, blocked, client_ip, protocol, device, root, tracker, encrypted, country, city, latitude, longitude, asn, asn_org)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING
+	`
+	deviceJSON := string(log.Device)
+	if deviceJSON == "" {
+		deviceJSON = "null"
+	}
+	result, err := s.db.Exec(query, log.ID, log.Profile, log.Timestamp, log.Domain, log.Type, log.Status,
+		log.Blocked, log.ClientIP, log.Protocol, deviceJSON, log.Root, log.Tracker, log.Encrypted,
+		log.Country, log.City, log.Latitude, log.Longitude, log.ASN, log.ASNOrg)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+func (s *SQLite) GetCursor(profile string) (string, error) {
+	var cursor string
+	err := s.db.QueryRow("SELECT value FROM sync_state WHERE key = ?", cursorKey(profile)).Scan(&cursor)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return cursor, err
+}
+
+func (s *SQLite) SetCursor(profile, cursor string) error {
+	query := `
+		INSERT INTO sync_state (key, value, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+	`
+	_, err := s.db.Exec(query, cursorKey(profile), cursor)
+	return err
+}
+
+func (s *SQLite) DeleteOlderThan(before time.Time, status string, excludeStatuses []string, limit int) (int64, error) {
+	args := []interface{}{before}
+	where := "timestamp < ?"
+
+	if status != "" {
+		args = append(args, status)
+		where += " AND status = ?"
+	}
+	for _, st := range excludeStatuses {
+		args = append(args, st)
+		where += " AND status != ?"
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+		DELETE FROM dns_logs WHERE id IN (
+			SELECT id FROM dns_logs WHERE %s LIMIT ?
+		)
+	`, where)
+
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}